@@ -0,0 +1,245 @@
+// Command client is a terminal chat client for the GO-RTCS server, built on
+// Bubble Tea. It dials the server's websocket endpoint, renders incoming
+// broadcasts in a scrolling viewport, and sends whatever is typed into the
+// input box below it.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"net/url"
+
+	"github.com/charmbracelet/bubbles/textinput"
+	"github.com/charmbracelet/bubbles/viewport"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+)
+
+// maxChatMessageSize mirrors the server's chat-text limit (server/main.go)
+// so users get immediate local feedback when they exceed it instead of
+// finding out from a silently dropped message.
+const maxChatMessageSize = 50
+
+// maxFrameSize mirrors the server's conn.SetReadLimit: the wire format is a
+// whole JSON envelope, not bare chat text, so the frame's read limit has to
+// be comfortably larger than maxChatMessageSize to leave room for envelope
+// overhead (id, type, action, payload keys).
+const maxFrameSize = 1024
+
+var (
+	addr      = flag.String("addr", "localhost:8080", "server address")
+	channelID = flag.String("channel", "default", "channel to join")
+	token     = flag.String("token", "", "auth token for the server")
+)
+
+// broadcastMessage mirrors server.BroadcastMessage; field names match so it
+// unmarshals without tags.
+type broadcastMessage struct {
+	SenderUUID string
+	Message    string
+}
+
+// envelope mirrors dispatcher.Envelope closely enough to send a broadcast
+// frame without importing the server module.
+type envelope struct {
+	ID      string          `json:"id"`
+	Type    string          `json:"type"`
+	Action  string          `json:"action"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+}
+
+type incomingFrameMsg []byte
+type connClosedMsg struct{ err error }
+
+var selfStyle = lipgloss.NewStyle().Foreground(lipgloss.Color("42")).Bold(true)
+var otherStyle = lipgloss.NewStyle().Foreground(lipgloss.Color("39"))
+var errStyle = lipgloss.NewStyle().Foreground(lipgloss.Color("196"))
+
+type model struct {
+	conn  *websocket.Conn
+	self  string
+	vp    viewport.Model
+	input textinput.Model
+	lines []string
+	err   error
+}
+
+func newModel(conn *websocket.Conn, self string) model {
+	ti := textinput.New()
+	ti.Placeholder = "say something"
+	ti.CharLimit = maxChatMessageSize
+	ti.Focus()
+
+	vp := viewport.New(80, 20)
+
+	return model{conn: conn, self: self, vp: vp, input: ti}
+}
+
+func (m model) Init() tea.Cmd {
+	return textinput.Blink
+}
+
+func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case tea.WindowSizeMsg:
+		m.vp.Width = msg.Width
+		m.vp.Height = msg.Height - 3
+		m.input.Width = msg.Width
+
+	case tea.KeyMsg:
+		switch msg.Type {
+		case tea.KeyCtrlC:
+			m.conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+			return m, tea.Quit
+		case tea.KeyEnter:
+			text := m.input.Value()
+			if text == "" {
+				return m, nil
+			}
+			if len(text) > maxChatMessageSize {
+				m.lines = append(m.lines, errStyle.Render(fmt.Sprintf("message too long (%d > %d bytes)", len(text), maxChatMessageSize)))
+				m.vp.SetContent(m.renderLines())
+				m.input.SetValue("")
+				return m, nil
+			}
+			if err := m.sendBroadcast(text); err != nil {
+				m.lines = append(m.lines, errStyle.Render(err.Error()))
+				m.vp.SetContent(m.renderLines())
+			}
+			m.input.SetValue("")
+			return m, nil
+		}
+
+	case incomingFrameMsg:
+		var bm broadcastMessage
+		if err := json.Unmarshal(msg, &bm); err == nil && bm.Message != "" {
+			m.lines = append(m.lines, m.renderMessage(bm))
+			m.vp.SetContent(m.renderLines())
+			m.vp.GotoBottom()
+		}
+
+	case connClosedMsg:
+		m.err = msg.err
+		return m, tea.Quit
+	}
+
+	var cmd tea.Cmd
+	m.input, cmd = m.input.Update(msg)
+	return m, cmd
+}
+
+func (m model) View() string {
+	if m.err != nil {
+		return errStyle.Render(fmt.Sprintf("disconnected: %v\n", m.err))
+	}
+	return fmt.Sprintf("%s\n%s", m.vp.View(), m.input.View())
+}
+
+func (m model) renderMessage(bm broadcastMessage) string {
+	style := otherStyle
+	if bm.SenderUUID == m.self {
+		style = selfStyle
+	}
+	return style.Render(fmt.Sprintf("%s → %s", bm.SenderUUID, bm.Message))
+}
+
+func (m model) renderLines() string {
+	out := ""
+	for _, line := range m.lines {
+		out += line + "\n"
+	}
+	return out
+}
+
+// sendBroadcast wraps text in a broadcast envelope and writes it to the
+// connection, checking the marshaled envelope (not just the raw text)
+// against the server's actual frame limit before sending.
+func (m model) sendBroadcast(text string) error {
+	payload, err := json.Marshal(struct {
+		Message string `json:"message"`
+	}{Message: text})
+	if err != nil {
+		return err
+	}
+
+	env := envelope{
+		ID:      uuid.New().String(),
+		Type:    "broadcast",
+		Action:  "broadcast",
+		Payload: payload,
+	}
+
+	envBytes, err := json.Marshal(env)
+	if err != nil {
+		return err
+	}
+	if len(envBytes) > maxFrameSize {
+		return fmt.Errorf("envelope too large (%d > %d bytes)", len(envBytes), maxFrameSize)
+	}
+
+	return m.conn.WriteMessage(websocket.TextMessage, envBytes)
+}
+
+// readLoop forwards every frame read off conn into the running program via
+// p.Send, so the Bubble Tea update loop is the only thing that touches
+// model state.
+func readLoop(conn *websocket.Conn, p *tea.Program) {
+	for {
+		_, msg, err := conn.ReadMessage()
+		if err != nil {
+			p.Send(connClosedMsg{err})
+			return
+		}
+		p.Send(incomingFrameMsg(msg))
+	}
+}
+
+// selfFromToken pulls the "sub" claim out of the auth token so self-sent
+// messages can be recognized when the server echoes them back; the server
+// assigns the connection's userID from that same claim, so this is the
+// only way the client can know its own identity up front. The signature
+// isn't (and can't be) verified here — the client has no secret, only the
+// server does — so this is purely for local self/other styling, never a
+// security decision. Falls back to a random id if the token can't be
+// parsed, e.g. when running against a static-token server unauthenticated.
+func selfFromToken(token string) string {
+	parser := jwt.NewParser()
+	claims := jwt.MapClaims{}
+	if _, _, err := parser.ParseUnverified(token, claims); err == nil {
+		if sub, ok := claims["sub"].(string); ok && sub != "" {
+			return sub
+		}
+	}
+	return uuid.New().String()
+}
+
+func main() {
+	flag.Parse()
+
+	self := selfFromToken(*token)
+
+	u := url.URL{Scheme: "ws", Host: *addr, Path: "/ws/" + *channelID}
+	q := u.Query()
+	q.Set("token", *token)
+	u.RawQuery = q.Encode()
+
+	conn, _, err := websocket.DefaultDialer.Dial(u.String(), nil)
+	if err != nil {
+		log.Fatalf("dial %s: %v", u.String(), err)
+	}
+	defer conn.Close()
+
+	conn.SetReadLimit(maxFrameSize)
+
+	p := tea.NewProgram(newModel(conn, self), tea.WithAltScreen())
+	go readLoop(conn, p)
+
+	if _, err := p.Run(); err != nil {
+		log.Fatalf("program error: %v", err)
+	}
+}
@@ -0,0 +1,158 @@
+// Package dispatcher implements a request/response layer on top of raw
+// websocket frames. Clients exchange JSON envelopes instead of bare text,
+// which lets a "request" be correlated with its "response" by id, with a
+// timeout if no handler answers in time. The "broadcast" envelope type is
+// a pass-through for the server's existing fan-out path.
+package dispatcher
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Type is the kind of frame an Envelope carries.
+type Type string
+
+const (
+	TypeRequest   Type = "request"
+	TypeResponse  Type = "response"
+	TypeBroadcast Type = "broadcast"
+)
+
+// defaultMessageTimeout is used when a Dispatcher is created with a
+// non-positive timeout.
+const defaultMessageTimeout = 30 * time.Second
+
+// Envelope is the wire format clients and the server exchange instead of
+// raw, untyped text frames.
+type Envelope struct {
+	ID      string          `json:"id"`
+	Type    Type            `json:"type"`
+	Action  string          `json:"action"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+	Error   string          `json:"error,omitempty"`
+}
+
+// Handler answers a request's payload with a response payload, or an error
+// that gets surfaced to the caller as Envelope.Error.
+type Handler func(clientID string, payload json.RawMessage) (json.RawMessage, error)
+
+// pendingCall tracks one in-flight request: the channel its eventual
+// response is delivered on, and the timer that fires if nothing answers
+// in time.
+type pendingCall struct {
+	respCh chan *Envelope
+	timer  *time.Timer
+}
+
+// pendingKey identifies one in-flight request. Request ids are chosen by
+// the client, not the dispatcher, so the id alone isn't unique across
+// clients; namespacing by clientID keeps two clients that happen to reuse
+// the same request id from clobbering each other's pendingCall.
+type pendingKey struct {
+	clientID string
+	id       string
+}
+
+// Dispatcher routes inbound "request" envelopes to registered action
+// handlers and correlates their eventual response back to the caller.
+type Dispatcher struct {
+	mu       sync.Mutex
+	pending  map[pendingKey]*pendingCall
+	handlers map[string]Handler
+	timeout  time.Duration
+}
+
+// New creates a Dispatcher whose requests time out after messageTimeout.
+// A non-positive messageTimeout falls back to a 30s default.
+func New(messageTimeout time.Duration) *Dispatcher {
+	if messageTimeout <= 0 {
+		messageTimeout = defaultMessageTimeout
+	}
+	return &Dispatcher{
+		pending:  make(map[pendingKey]*pendingCall),
+		handlers: make(map[string]Handler),
+		timeout:  messageTimeout,
+	}
+}
+
+// Register associates a Handler with an action name found on inbound
+// "request" envelopes.
+func (d *Dispatcher) Register(action string, h Handler) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.handlers[action] = h
+}
+
+// Dispatch handles one inbound envelope from clientID and returns the
+// envelope the caller should write back to that client, or nil if nothing
+// should be written. "broadcast" envelopes are returned unchanged so the
+// caller can fan them out via the existing broadcast path; "request"
+// envelopes are routed to their handler and block until it answers or the
+// dispatcher's timeout elapses.
+func (d *Dispatcher) Dispatch(clientID string, env Envelope) *Envelope {
+	switch env.Type {
+	case TypeBroadcast:
+		return &env
+	case TypeRequest:
+		return d.dispatchRequest(clientID, env)
+	default:
+		return errorResponse(env.ID, fmt.Sprintf("unsupported envelope type %q", env.Type))
+	}
+}
+
+func (d *Dispatcher) dispatchRequest(clientID string, env Envelope) *Envelope {
+	d.mu.Lock()
+	handler, ok := d.handlers[env.Action]
+	d.mu.Unlock()
+	if !ok {
+		return errorResponse(env.ID, fmt.Sprintf("no handler for action %q", env.Action))
+	}
+
+	key := pendingKey{clientID: clientID, id: env.ID}
+	call := &pendingCall{respCh: make(chan *Envelope, 1)}
+	d.mu.Lock()
+	d.pending[key] = call
+	d.mu.Unlock()
+
+	call.timer = time.AfterFunc(d.timeout, func() {
+		if !d.clearPending(key) {
+			return
+		}
+		call.respCh <- errorResponse(env.ID, "request timed out")
+	})
+
+	go func() {
+		payload, err := handler(clientID, env.Payload)
+		if !d.clearPending(key) {
+			return // the timer already fired and delivered a response
+		}
+		call.timer.Stop()
+		if err != nil {
+			call.respCh <- errorResponse(env.ID, err.Error())
+			return
+		}
+		call.respCh <- &Envelope{ID: env.ID, Type: TypeResponse, Action: env.Action, Payload: payload}
+	}()
+
+	return <-call.respCh
+}
+
+// clearPending removes key from the pending set and reports whether it was
+// still there, so the timer and the handler goroutine can agree on which
+// of them gets to deliver the response.
+func (d *Dispatcher) clearPending(key pendingKey) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if _, ok := d.pending[key]; !ok {
+		return false
+	}
+	delete(d.pending, key)
+	return true
+}
+
+func errorResponse(id, msg string) *Envelope {
+	return &Envelope{ID: id, Type: TypeResponse, Error: msg}
+}
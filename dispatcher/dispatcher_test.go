@@ -0,0 +1,133 @@
+package dispatcher
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestDispatchRequestRoutesToHandler(t *testing.T) {
+	d := New(time.Second)
+	d.Register("echo", func(clientID string, payload json.RawMessage) (json.RawMessage, error) {
+		return payload, nil
+	})
+
+	req := Envelope{ID: "1", Type: TypeRequest, Action: "echo", Payload: json.RawMessage(`"hi"`)}
+	resp := d.Dispatch("client-a", req)
+
+	if resp == nil {
+		t.Fatal("expected a response envelope, got nil")
+	}
+	if resp.ID != req.ID {
+		t.Errorf("ID = %q, want %q", resp.ID, req.ID)
+	}
+	if resp.Type != TypeResponse {
+		t.Errorf("Type = %q, want %q", resp.Type, TypeResponse)
+	}
+	if string(resp.Payload) != `"hi"` {
+		t.Errorf("Payload = %s, want %q", resp.Payload, `"hi"`)
+	}
+	if resp.Error != "" {
+		t.Errorf("Error = %q, want empty", resp.Error)
+	}
+}
+
+func TestDispatchRequestHandlerError(t *testing.T) {
+	d := New(time.Second)
+	d.Register("boom", func(clientID string, payload json.RawMessage) (json.RawMessage, error) {
+		return nil, errors.New("kaboom")
+	})
+
+	resp := d.Dispatch("client-a", Envelope{ID: "1", Type: TypeRequest, Action: "boom"})
+
+	if resp == nil || resp.Error != "kaboom" {
+		t.Fatalf("resp = %+v, want Error = %q", resp, "kaboom")
+	}
+}
+
+func TestDispatchRequestUnknownAction(t *testing.T) {
+	d := New(time.Second)
+
+	resp := d.Dispatch("client-a", Envelope{ID: "1", Type: TypeRequest, Action: "missing"})
+
+	if resp == nil || resp.Error == "" {
+		t.Fatalf("resp = %+v, want a non-empty Error", resp)
+	}
+}
+
+func TestDispatchRequestTimeout(t *testing.T) {
+	d := New(20 * time.Millisecond)
+	unblock := make(chan struct{})
+	d.Register("slow", func(clientID string, payload json.RawMessage) (json.RawMessage, error) {
+		<-unblock
+		return json.RawMessage(`{}`), nil
+	})
+	defer close(unblock)
+
+	start := time.Now()
+	resp := d.Dispatch("client-a", Envelope{ID: "1", Type: TypeRequest, Action: "slow"})
+	elapsed := time.Since(start)
+
+	if resp == nil || resp.Error != "request timed out" {
+		t.Fatalf("resp = %+v, want Error = %q", resp, "request timed out")
+	}
+	if elapsed > time.Second {
+		t.Errorf("Dispatch took %v, want it to return promptly after the timeout", elapsed)
+	}
+}
+
+func TestDispatchBroadcastPassesThrough(t *testing.T) {
+	d := New(time.Second)
+	env := Envelope{ID: "1", Type: TypeBroadcast, Payload: json.RawMessage(`{"message":"hi"}`)}
+
+	resp := d.Dispatch("client-a", env)
+
+	if resp == nil || resp.Type != TypeBroadcast || string(resp.Payload) != string(env.Payload) {
+		t.Errorf("Dispatch(broadcast) = %+v, want the envelope unchanged", resp)
+	}
+}
+
+func TestClearPendingOnlyFiresOnce(t *testing.T) {
+	d := New(time.Second)
+	key := pendingKey{clientID: "client-a", id: "1"}
+	d.pending[key] = &pendingCall{respCh: make(chan *Envelope, 1)}
+
+	if !d.clearPending(key) {
+		t.Fatal("first clearPending should report the entry was pending")
+	}
+	if d.clearPending(key) {
+		t.Fatal("second clearPending should report nothing left to clear")
+	}
+}
+
+func TestDispatchRequestSameIDDifferentClients(t *testing.T) {
+	d := New(time.Second)
+	unblockA := make(chan struct{})
+	d.Register("slow", func(clientID string, payload json.RawMessage) (json.RawMessage, error) {
+		if clientID == "client-a" {
+			<-unblockA
+		}
+		return json.RawMessage(`{}`), nil
+	})
+
+	done := make(chan *Envelope, 1)
+	go func() {
+		done <- d.Dispatch("client-a", Envelope{ID: "1", Type: TypeRequest, Action: "slow"})
+	}()
+
+	resp := d.Dispatch("client-b", Envelope{ID: "1", Type: TypeRequest, Action: "slow"})
+	if resp == nil || resp.Error != "" {
+		t.Fatalf("client-b resp = %+v, want a successful response", resp)
+	}
+
+	close(unblockA)
+	select {
+	case resp := <-done:
+		if resp == nil || resp.Error != "" {
+			t.Fatalf("client-a resp = %+v, want a successful response", resp)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("client-a's request never completed; its pendingCall was likely clobbered by client-b's same-id request")
+	}
+}
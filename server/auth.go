@@ -0,0 +1,96 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// ErrInvalidToken is returned by an Authenticator when the supplied token is
+// missing, malformed, or fails verification.
+var ErrInvalidToken = errors.New("invalid token")
+
+// Authenticator validates a bearer token presented on connect and resolves
+// it to a stable userID. remote is passed through so implementations can
+// factor the peer address into their decision (e.g. IP allowlisting).
+type Authenticator interface {
+	Authenticate(token string, remote net.Addr) (userID string, err error)
+}
+
+// JWTAuthenticator validates HMAC-signed JWTs and uses the "sub" claim as
+// the userID.
+type JWTAuthenticator struct {
+	secret []byte
+}
+
+// NewJWTAuthenticator builds a JWTAuthenticator that verifies tokens signed
+// with the given HMAC secret.
+func NewJWTAuthenticator(secret []byte) *JWTAuthenticator {
+	return &JWTAuthenticator{secret: secret}
+}
+
+func (a *JWTAuthenticator) Authenticate(token string, _ net.Addr) (string, error) {
+	if token == "" {
+		return "", ErrInvalidToken
+	}
+
+	parsed, err := jwt.Parse(token, func(t *jwt.Token) (interface{}, error) {
+		if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("unexpected signing method: %v", t.Header["alg"])
+		}
+		return a.secret, nil
+	})
+	if err != nil || !parsed.Valid {
+		return "", ErrInvalidToken
+	}
+
+	claims, ok := parsed.Claims.(jwt.MapClaims)
+	if !ok {
+		return "", ErrInvalidToken
+	}
+
+	userID, ok := claims["sub"].(string)
+	if !ok || userID == "" {
+		return "", ErrInvalidToken
+	}
+
+	return userID, nil
+}
+
+// StaticTokenAuthenticator resolves tokens from a fixed in-memory map. It
+// exists for tests and local development where standing up a JWT issuer
+// isn't worth it.
+type StaticTokenAuthenticator struct {
+	tokens map[string]string
+}
+
+// NewStaticTokenAuthenticator builds a StaticTokenAuthenticator backed by
+// the given token -> userID map.
+func NewStaticTokenAuthenticator(tokens map[string]string) *StaticTokenAuthenticator {
+	return &StaticTokenAuthenticator{tokens: tokens}
+}
+
+func (a *StaticTokenAuthenticator) Authenticate(token string, _ net.Addr) (string, error) {
+	userID, ok := a.tokens[token]
+	if !ok {
+		return "", ErrInvalidToken
+	}
+	return userID, nil
+}
+
+// tokenFromRequest extracts the bearer token from either a "token" query
+// parameter or an "Authorization: Bearer <token>" header, preferring the
+// header when both are present.
+func tokenFromRequest(r *http.Request) string {
+	if auth := r.Header.Get("Authorization"); auth != "" {
+		const prefix = "Bearer "
+		if strings.HasPrefix(auth, prefix) {
+			return strings.TrimPrefix(auth, prefix)
+		}
+	}
+	return r.URL.Query().Get("token")
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	// defaultBufferCap bounds how many missed broadcasts we hold per client.
+	defaultBufferCap = 50
+	// defaultBufferTTL is how long a missed broadcast stays eligible for replay.
+	defaultBufferTTL = 5 * time.Minute
+	// bufferSweepInterval controls how often expired entries are purged.
+	bufferSweepInterval = 30 * time.Second
+)
+
+// bufferedMessage is a broadcast frame a client missed while disconnected,
+// tagged with the time after which it's no longer worth replaying.
+type bufferedMessage struct {
+	data        []byte
+	releaseTime time.Time
+}
+
+// MessageBuffer holds per-client backlogs of missed broadcasts so short
+// disconnects don't lose messages. It's a plain mutex-guarded map of slices
+// used as a head/tail queue; capacity and TTL keep memory bounded.
+type MessageBuffer struct {
+	mu       sync.Mutex
+	entries  map[string][]bufferedMessage
+	capacity int
+	ttl      time.Duration
+}
+
+// NewMessageBuffer creates a buffer holding up to capacity messages per
+// client, each eligible for replay for ttl after it was enqueued.
+func NewMessageBuffer(capacity int, ttl time.Duration) *MessageBuffer {
+	return &MessageBuffer{
+		entries:  make(map[string][]bufferedMessage),
+		capacity: capacity,
+		ttl:      ttl,
+	}
+}
+
+// Enqueue appends msg to clientID's backlog, dropping the oldest entry once
+// the per-client cap is reached.
+func (b *MessageBuffer) Enqueue(clientID string, msg []byte) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	queue := append(b.entries[clientID], bufferedMessage{
+		data:        msg,
+		releaseTime: time.Now().Add(b.ttl),
+	})
+	if len(queue) > b.capacity {
+		queue = queue[len(queue)-b.capacity:]
+	}
+	b.entries[clientID] = queue
+}
+
+// Drain removes and returns clientID's backlog in order, skipping entries
+// that have already expired.
+func (b *MessageBuffer) Drain(clientID string) [][]byte {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	queue := b.entries[clientID]
+	delete(b.entries, clientID)
+
+	now := time.Now()
+	replay := make([][]byte, 0, len(queue))
+	for _, entry := range queue {
+		if now.Before(entry.releaseTime) {
+			replay = append(replay, entry.data)
+		}
+	}
+	return replay
+}
+
+// expireExpired purges entries past their TTL for clients who never
+// reconnect to drain them, so their backlog doesn't grow unbounded.
+func (b *MessageBuffer) expireExpired() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	for clientID, queue := range b.entries {
+		live := queue[:0]
+		for _, entry := range queue {
+			if now.Before(entry.releaseTime) {
+				live = append(live, entry)
+			}
+		}
+		if len(live) == 0 {
+			delete(b.entries, clientID)
+		} else {
+			b.entries[clientID] = live
+		}
+	}
+}
+
+// runExpiry periodically sweeps expired entries until stop is closed.
+func (b *MessageBuffer) runExpiry(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			b.expireExpired()
+		case <-stop:
+			return
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMessageBufferDrainPreservesOrder(t *testing.T) {
+	b := NewMessageBuffer(10, time.Minute)
+	b.Enqueue("client-a", []byte("one"))
+	b.Enqueue("client-a", []byte("two"))
+	b.Enqueue("client-a", []byte("three"))
+
+	got := b.Drain("client-a")
+	want := []string{"one", "two", "three"}
+	if len(got) != len(want) {
+		t.Fatalf("Drain returned %d messages, want %d", len(got), len(want))
+	}
+	for i, w := range want {
+		if string(got[i]) != w {
+			t.Errorf("message %d = %q, want %q", i, got[i], w)
+		}
+	}
+}
+
+func TestMessageBufferDrainEmptiesBacklog(t *testing.T) {
+	b := NewMessageBuffer(10, time.Minute)
+	b.Enqueue("client-a", []byte("one"))
+	b.Drain("client-a")
+
+	if got := b.Drain("client-a"); len(got) != 0 {
+		t.Errorf("second Drain returned %d messages, want 0", len(got))
+	}
+}
+
+func TestMessageBufferCapacityDropsOldest(t *testing.T) {
+	b := NewMessageBuffer(2, time.Minute)
+	b.Enqueue("client-a", []byte("one"))
+	b.Enqueue("client-a", []byte("two"))
+	b.Enqueue("client-a", []byte("three"))
+
+	got := b.Drain("client-a")
+	want := []string{"two", "three"}
+	if len(got) != len(want) {
+		t.Fatalf("Drain returned %d messages, want %d", len(got), len(want))
+	}
+	for i, w := range want {
+		if string(got[i]) != w {
+			t.Errorf("message %d = %q, want %q", i, got[i], w)
+		}
+	}
+}
+
+func TestMessageBufferDrainSkipsExpired(t *testing.T) {
+	b := NewMessageBuffer(10, time.Millisecond)
+	b.Enqueue("client-a", []byte("stale"))
+	time.Sleep(5 * time.Millisecond)
+	b.Enqueue("client-a", []byte("fresh"))
+
+	// "fresh" is enqueued with its own ttl at enqueue time, so it's still
+	// alive even though "stale" has expired by the time we drain.
+	got := b.Drain("client-a")
+	if len(got) != 1 || string(got[0]) != "fresh" {
+		t.Fatalf("Drain = %v, want only the unexpired \"fresh\" entry", stringsOf(got))
+	}
+}
+
+func TestMessageBufferExpireExpiredPurgesStaleClients(t *testing.T) {
+	b := NewMessageBuffer(10, time.Millisecond)
+	b.Enqueue("client-a", []byte("stale"))
+	time.Sleep(5 * time.Millisecond)
+
+	b.expireExpired()
+
+	b.mu.Lock()
+	_, ok := b.entries["client-a"]
+	b.mu.Unlock()
+	if ok {
+		t.Error("expireExpired left an entry for a client with no live messages")
+	}
+}
+
+func stringsOf(bs [][]byte) []string {
+	out := make([]string, len(bs))
+	for i, b := range bs {
+		out[i] = string(b)
+	}
+	return out
+}
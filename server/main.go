@@ -2,24 +2,54 @@ package main
 
 import (
 	"encoding/json"
+	"flag"
 	"log/slog"
+	"net"
 	"net/http"
 	"os"
 	"strings"
 	"sync"
 	"time"
 
-	"github.com/google/uuid"
+	"github.com/gorilla/mux"
 	"github.com/gorilla/websocket"
+
+	"github.com/prasdud/GO-RTCS/dispatcher"
 )
 
+// originAllowlist holds the origins CheckOrigin accepts; populated from
+// flag/env in main before the server starts listening.
+var originAllowlist []string
+
+func checkOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		// Non-browser clients (e.g. the bundled TUI client) don't send Origin.
+		return true
+	}
+	for _, allowed := range originAllowlist {
+		if allowed == origin {
+			return true
+		}
+	}
+	return false
+}
+
 var upgrader = websocket.Upgrader{
-	CheckOrigin:      func(r *http.Request) bool { return true }, // Allow all origins, change this before deployment
+	CheckOrigin:      checkOrigin,
 	HandshakeTimeout: 10 * time.Second,
 	ReadBufferSize:   1024, // buffer allocated by HTTP server used here
 	WriteBufferSize:  1024,
 }
 
+// authenticator validates the bearer token presented on connect; wired up
+// in main, defaulting to HMAC JWTs.
+var authenticator Authenticator
+
+// disp routes request/response envelopes to their action handlers; see
+// registerDispatcherHandlers.
+var disp *dispatcher.Dispatcher
+
 var logger = slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{
 	ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
 		if a.Key == slog.TimeKey {
@@ -34,9 +64,62 @@ var logger = slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{
 	},
 }))
 
+const (
+	// Time allowed to write a message to the peer.
+	writeWait = 10 * time.Second
+	// Time allowed to read the next pong message from the peer.
+	pongWait = 60 * time.Second
+	// Send pings to peer with this period. Must be less than pongWait.
+	pingPeriod = 54 * time.Second
+	// sendBufferSize bounds how many outbound messages we'll queue for a
+	// slow client before we give up on it.
+	sendBufferSize = 256
+	// maxChatMessageSize is the limit on a chat message's text, enforced on
+	// the decoded payload in handleBroadcast.
+	maxChatMessageSize = 50
+	// maxFrameSize bounds the raw websocket frame; an envelope wraps the
+	// chat text in JSON (id, type, action, payload keys), so this has to be
+	// comfortably larger than maxChatMessageSize to avoid killing the
+	// connection on envelope overhead alone.
+	maxFrameSize = 1024
+)
+
+// Client wraps a connection with its own outbound buffer so a slow reader
+// never blocks the broadcast loop or other clients' writers.
+type Client struct {
+	id        string
+	channelID string
+	conn      *websocket.Conn
+	send      chan []byte
+	closeOnce sync.Once
+}
+
+// closeSend closes the client's send channel exactly once. Both readPump's
+// disconnect cleanup and broadcast's slow-client drop path can reach this
+// for the same client, and closing an already-closed channel panics, so
+// every caller must go through here instead of calling close(c.send) directly.
+func (c *Client) closeSend() {
+	c.closeOnce.Do(func() {
+		close(c.send)
+	})
+}
+
 var (
-	connectedClients = make(map[string]*websocket.Conn)
-	clientsMutex     sync.RWMutex
+	// connectedClients is keyed by channelID -> clientID -> client so broadcasts
+	// can be scoped to the room a client is subscribed to.
+	connectedClients = make(map[string]map[string]*Client)
+	// channelRoster remembers every clientID that has joined a channel, so
+	// broadcasts know who still needs replay after it disconnects. The value
+	// is the time the client went offline (zero while still connected);
+	// sweepChannelRoster expires entries past defaultBufferTTL so churn
+	// doesn't grow this map forever.
+	channelRoster = make(map[string]map[string]time.Time)
+	// clientChannel maps a connected clientID to the channel it joined, so
+	// dispatcher handlers can look up a client's room without a linear scan.
+	clientChannel = make(map[string]string)
+	clientsMutex  sync.RWMutex
+
+	messageBuffer = NewMessageBuffer(defaultBufferCap, defaultBufferTTL)
 )
 
 type BroadcastMessage struct {
@@ -44,47 +127,115 @@ type BroadcastMessage struct {
 	Message    string
 }
 
+// ChannelInfo is the JSON shape returned by GET /channels.
+type ChannelInfo struct {
+	ChannelID string `json:"channelId"`
+	Clients   int    `json:"clients"`
+}
+
 func wsHandler(w http.ResponseWriter, r *http.Request) {
+	channelID := mux.Vars(r)["channelID"]
+	if channelID == "" {
+		channelID = "default"
+	}
+
+	token := tokenFromRequest(r)
+	remoteAddr, _ := net.ResolveTCPAddr("tcp", r.RemoteAddr)
+	userID, err := authenticator.Authenticate(token, remoteAddr)
+	if err != nil {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
 	conn, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
 		logger.Error("Upgrade error", "error", err)
 		return
 	}
 
-	const maxMessageSize = 50
-	conn.SetReadLimit(maxMessageSize)
-	clientId := uuid.New().String()
+	// maxFrameSize bounds the raw websocket frame, which is now a whole JSON
+	// envelope ({"id":...,"type":...,"action":...,"payload":{...}}) rather
+	// than bare chat text, so it has to leave room for that wrapping on top
+	// of maxChatMessageSize; the chat text itself is still capped separately
+	// in handleBroadcast.
+	conn.SetReadLimit(maxFrameSize)
 
-	// custom defer to track client disconnection
-	defer func() {
-		//logger.Info("Client disconnected", "address", clientId)
+	client := &Client{
+		id:        userID,
+		channelID: channelID,
+		conn:      conn,
+		send:      make(chan []byte, sendBufferSize),
+	}
 
+	clientsMutex.Lock()
+	if connectedClients[channelID] == nil {
+		connectedClients[channelID] = make(map[string]*Client)
+	}
+	if channelRoster[channelID] == nil {
+		channelRoster[channelID] = make(map[string]time.Time)
+	}
+	connectedClients[channelID][client.id] = client
+	channelRoster[channelID][client.id] = time.Time{} // zero value marks "currently connected"
+	clientChannel[client.id] = channelID
+	count := len(connectedClients[channelID])
+	clientsMutex.Unlock()
+
+	logger.Info("Client connected", "address", client.id, "channel", channelID, "total", count)
+
+	go client.writePump()
+
+	// Replay anything the client missed while disconnected before it
+	// re-enters the normal read loop.
+	for _, msg := range messageBuffer.Drain(client.id) {
+		client.send <- msg
+	}
+
+	client.readPump()
+}
+
+// readPump owns the read side of the connection: it enforces the pong
+// deadline, decodes inbound messages and fans them out to the channel.
+// It runs on the goroutine that called wsHandler and exits (closing the
+// connection) when the read loop ends.
+func (c *Client) readPump() {
+	defer func() {
 		clientsMutex.Lock()
-		delete(connectedClients, clientId)
-		count := len(connectedClients)
+		// Only tear down c's own registration. If c reconnected (same id,
+		// same JWT sub) while this stale connection's teardown was still in
+		// flight, connectedClients[...] now holds the new live Client, and
+		// we must not delete/mark-offline out from under it.
+		if connectedClients[c.channelID][c.id] == c {
+			delete(connectedClients[c.channelID], c.id)
+			delete(clientChannel, c.id)
+			if channelRoster[c.channelID] != nil {
+				channelRoster[c.channelID][c.id] = time.Now()
+			}
+		}
+		count := len(connectedClients[c.channelID])
+		if count == 0 {
+			delete(connectedClients, c.channelID)
+		}
 		clientsMutex.Unlock()
 
-		logger.Info("Total active clients", "total", count)
-		conn.Close()
+		logger.Info("Total active clients in channel", "channel", c.channelID, "total", count)
+		c.closeSend()
+		c.conn.Close()
 	}()
 
-	// Track connected client
-	//clientId := r.RemoteAddr
-	clientsMutex.Lock()
-	connectedClients[clientId] = conn
-	count := len(connectedClients)
-	clientsMutex.Unlock()
-
-	logger.Info("Client connected", "address", clientId, "total", count)
+	c.conn.SetReadDeadline(time.Now().Add(pongWait))
+	c.conn.SetPongHandler(func(string) error {
+		c.conn.SetReadDeadline(time.Now().Add(pongWait))
+		return nil
+	})
 
 	for {
 		// Read message from client (blocks until message received)
-		_, msg, err := conn.ReadMessage()
+		_, msg, err := c.conn.ReadMessage()
 
 		if err != nil {
 			// Check for close errors and log gracefully
 			if websocket.IsUnexpectedCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
-				logger.Error("Client disconnected", "address", clientId, "error", err)
+				logger.Error("Client disconnected", "address", c.id, "error", err)
 			} else {
 				logger.Info("Read error", "error", err)
 			}
@@ -98,37 +249,246 @@ func wsHandler(w http.ResponseWriter, r *http.Request) {
 			continue
 		}
 
-		logger.Info("Received message", "message", strings.TrimSpace(msgString), "from", clientId)
+		var env dispatcher.Envelope
+		if err := json.Unmarshal(msg, &env); err != nil {
+			logger.Info("Malformed envelope", "error", err, "from", c.id)
+			continue
+		}
+
+		logger.Info("Received envelope", "type", env.Type, "action", env.Action, "from", c.id, "channel", c.channelID)
 
-		currentBroadcast := BroadcastMessage{
-			SenderUUID: clientId,
-			Message:    msgString,
+		switch env.Type {
+		case dispatcher.TypeBroadcast:
+			c.handleBroadcast(env)
+		case dispatcher.TypeRequest:
+			// Dispatch blocks until a handler answers or messageTimeout
+			// elapses; run it off the read loop so one outstanding request
+			// doesn't stall reads (and the read deadline) for the rest of
+			// the connection's lifetime.
+			go func(env dispatcher.Envelope) {
+				if resp := disp.Dispatch(c.id, env); resp != nil {
+					c.writeEnvelope(*resp)
+				}
+			}(env)
+		default:
+			logger.Info("Unsupported envelope type", "type", env.Type, "from", c.id)
 		}
+	}
+}
 
-		// acquire lock to broadcast message to all clients
-		// iterate through map of connected clients and send message
-		clientsMutex.Lock()
-		//currentConn := connectedClients[clientId]
-		for _, clientConn := range connectedClients {
-			msgBytes, err := json.Marshal(currentBroadcast)
-			if err != nil {
-				logger.Error("JSON marshal error", "error", err)
-				continue
+// handleBroadcast unwraps a "broadcast" envelope's payload and fans the
+// chat message out to the client's channel, same as the old untyped path.
+func (c *Client) handleBroadcast(env dispatcher.Envelope) {
+	var payload struct {
+		Message string `json:"message"`
+	}
+	if err := json.Unmarshal(env.Payload, &payload); err != nil {
+		logger.Error("Broadcast payload error", "error", err, "from", c.id)
+		return
+	}
+	if len(payload.Message) > maxChatMessageSize {
+		logger.Info("Message too long", "size", len(payload.Message), "limit", maxChatMessageSize, "from", c.id)
+		return
+	}
+
+	currentBroadcast := BroadcastMessage{
+		SenderUUID: c.id,
+		Message:    payload.Message,
+	}
+
+	msgBytes, err := json.Marshal(currentBroadcast)
+	if err != nil {
+		logger.Error("JSON marshal error", "error", err)
+		return
+	}
+
+	broadcast(c.channelID, msgBytes)
+}
+
+// writeEnvelope queues env on the client's send channel, dropping it if the
+// client is already backed up rather than blocking the read loop.
+func (c *Client) writeEnvelope(env dispatcher.Envelope) {
+	envBytes, err := json.Marshal(env)
+	if err != nil {
+		logger.Error("Envelope marshal error", "error", err)
+		return
+	}
+	select {
+	case c.send <- envBytes:
+	default:
+		logger.Error("Dropping response, client backed up", "to", c.id)
+	}
+}
+
+// writePump owns the write side of the connection so the broadcast loop
+// never blocks on a slow peer. It drains the client's send channel and
+// drives the ping ticker; either one returning ends the connection.
+func (c *Client) writePump() {
+	ticker := time.NewTicker(pingPeriod)
+	defer func() {
+		ticker.Stop()
+		c.conn.Close()
+	}()
+
+	for {
+		select {
+		case msg, ok := <-c.send:
+			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if !ok {
+				c.conn.WriteMessage(websocket.CloseMessage, []byte{})
+				return
 			}
-			if err := clientConn.WriteMessage(websocket.TextMessage, msgBytes); err != nil {
-				logger.Error("Broadcast error", "error", err)
-				break
+			if err := c.conn.WriteMessage(websocket.TextMessage, msg); err != nil {
+				logger.Error("Broadcast error", "error", err, "to", c.id)
+				return
+			}
+		case <-ticker.C:
+			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if err := c.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
 			}
 		}
-		clientsMutex.Unlock()
 	}
 }
 
+// broadcast fans msgBytes out to every known member of channelID. Clients
+// currently connected whose send buffer is full are assumed dead and
+// dropped; clients not currently connected (or just dropped) get the
+// message enqueued in their MessageBuffer for replay on reconnect, so the
+// delivery is at-least-once across short disconnects instead of best-effort.
+func broadcast(channelID string, msgBytes []byte) {
+	clientsMutex.Lock()
+	defer clientsMutex.Unlock()
+
+	for id := range channelRoster[channelID] {
+		client, online := connectedClients[channelID][id]
+		if !online {
+			messageBuffer.Enqueue(id, msgBytes)
+			continue
+		}
+
+		select {
+		case client.send <- msgBytes:
+		default:
+			logger.Error("Dropping slow client", "address", id, "channel", channelID)
+			// Safe without a separate identity check: client was looked up
+			// under the same clientsMutex hold we're still in, so the map
+			// can't have been swapped to a different *Client underneath us.
+			delete(connectedClients[channelID], id)
+			// readPump's teardown skips a client no longer in
+			// connectedClients, so mark it offline here.
+			channelRoster[channelID][id] = time.Now()
+			if clientChannel[id] == channelID {
+				delete(clientChannel, id)
+			}
+			client.closeSend()
+			messageBuffer.Enqueue(id, msgBytes)
+		}
+	}
+}
+
+// sweepChannelRoster drops roster entries for clients that have been
+// offline for longer than ttl, so channel churn doesn't grow the roster (and
+// the per-broadcast work of iterating it) without bound.
+func sweepChannelRoster(ttl time.Duration) {
+	clientsMutex.Lock()
+	defer clientsMutex.Unlock()
+
+	now := time.Now()
+	for channelID, members := range channelRoster {
+		for id, disconnectedAt := range members {
+			if !disconnectedAt.IsZero() && now.Sub(disconnectedAt) > ttl {
+				delete(members, id)
+			}
+		}
+		if len(members) == 0 {
+			delete(channelRoster, channelID)
+		}
+	}
+}
+
+// runRosterSweep periodically expires offline roster entries until stop is
+// closed.
+func runRosterSweep(interval, ttl time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			sweepChannelRoster(ttl)
+		case <-stop:
+			return
+		}
+	}
+}
+
+// channelsHandler reports the active channels and their member counts so
+// operators can see fan-out activity without reaching into server state.
+func channelsHandler(w http.ResponseWriter, r *http.Request) {
+	clientsMutex.RLock()
+	channels := make([]ChannelInfo, 0, len(connectedClients))
+	for channelID, clients := range connectedClients {
+		channels = append(channels, ChannelInfo{ChannelID: channelID, Clients: len(clients)})
+	}
+	clientsMutex.RUnlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(channels); err != nil {
+		logger.Error("Channels encode error", "error", err)
+	}
+}
+
+// registerDispatcherHandlers wires up the built-in request actions. Other
+// actions (e.g. private messaging) can be registered the same way.
+func registerDispatcherHandlers(d *dispatcher.Dispatcher) {
+	d.Register("online", func(clientID string, _ json.RawMessage) (json.RawMessage, error) {
+		clientsMutex.RLock()
+		channelID := clientChannel[clientID]
+		ids := make([]string, 0, len(connectedClients[channelID]))
+		for id := range connectedClients[channelID] {
+			ids = append(ids, id)
+		}
+		clientsMutex.RUnlock()
+
+		return json.Marshal(ids)
+	})
+}
+
+// newRouter builds the server's route table. Split out from main so tests
+// can stand up the real handlers against an httptest server.
+func newRouter() *mux.Router {
+	router := mux.NewRouter()
+	router.HandleFunc("/ws/{channelID}", wsHandler)
+	router.HandleFunc("/ws", wsHandler)
+	router.HandleFunc("/channels", channelsHandler).Methods(http.MethodGet)
+	return router
+}
+
 func main() {
-	http.HandleFunc("/ws", wsHandler)
+	originsFlag := flag.String("allowed-origins", os.Getenv("ALLOWED_ORIGINS"), "comma-separated list of allowed WebSocket origins")
+	jwtSecretFlag := flag.String("jwt-secret", os.Getenv("JWT_SECRET"), "HMAC secret used to verify connection JWTs")
+	messageTimeoutFlag := flag.Duration("message-timeout", 30*time.Second, "how long a dispatcher request waits for its handler")
+	flag.Parse()
+
+	if *originsFlag != "" {
+		originAllowlist = strings.Split(*originsFlag, ",")
+	}
+	if *jwtSecretFlag == "" {
+		logger.Error("refusing to start: -jwt-secret/JWT_SECRET is empty, which would accept tokens signed with a well-known empty secret")
+		os.Exit(1)
+	}
+	authenticator = NewJWTAuthenticator([]byte(*jwtSecretFlag))
+
+	disp = dispatcher.New(*messageTimeoutFlag)
+	registerDispatcherHandlers(disp)
+
+	go messageBuffer.runExpiry(bufferSweepInterval, nil)
+	go runRosterSweep(bufferSweepInterval, defaultBufferTTL, nil)
+
 	logger.Info("WebSocket server started on :8080")
 
-	if err := http.ListenAndServe(":8080", nil); err != nil {
+	if err := http.ListenAndServe(":8080", newRouter()); err != nil {
 		logger.Error("Server error", "error", err)
 	}
 }
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/prasdud/GO-RTCS/dispatcher"
+)
+
+// dialClient connects to the test server as userID (authenticated via a
+// static token matching userID) and joins channelID.
+func dialClient(t *testing.T, wsURL, channelID, userID string) *websocket.Conn {
+	t.Helper()
+	u := strings.Replace(wsURL, "http://", "ws://", 1) + "/ws/" + channelID + "?token=" + userID
+	conn, _, err := websocket.DefaultDialer.Dial(u, nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// TestBroadcastEndToEnd is a smoke test for the thing a user actually does:
+// type a message and have it arrive at another client. It exercises the
+// real route table, upgrade/auth path, envelope decoding, and broadcast fan
+// out together, which unit tests on the individual pieces don't cover.
+func TestBroadcastEndToEnd(t *testing.T) {
+	authenticator = NewStaticTokenAuthenticator(map[string]string{
+		"alice": "alice",
+		"bob":   "bob",
+	})
+	disp = dispatcher.New(time.Second)
+	registerDispatcherHandlers(disp)
+
+	srv := httptest.NewServer(newRouter())
+	defer srv.Close()
+
+	alice := dialClient(t, srv.URL, "lobby", "alice")
+	bob := dialClient(t, srv.URL, "lobby", "bob")
+
+	// Give both connections a beat to register before broadcasting.
+	time.Sleep(50 * time.Millisecond)
+
+	payload, err := json.Marshal(struct {
+		Message string `json:"message"`
+	}{Message: "hi bob"})
+	if err != nil {
+		t.Fatalf("marshal payload: %v", err)
+	}
+	env := dispatcher.Envelope{ID: "1", Type: dispatcher.TypeBroadcast, Action: "broadcast", Payload: payload}
+	envBytes, err := json.Marshal(env)
+	if err != nil {
+		t.Fatalf("marshal envelope: %v", err)
+	}
+	if err := alice.WriteMessage(websocket.TextMessage, envBytes); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+
+	bob.SetReadDeadline(time.Now().Add(2 * time.Second))
+	_, msg, err := bob.ReadMessage()
+	if err != nil {
+		t.Fatalf("bob never received the broadcast: %v", err)
+	}
+
+	var got BroadcastMessage
+	if err := json.Unmarshal(msg, &got); err != nil {
+		t.Fatalf("unmarshal broadcast: %v", err)
+	}
+	if got.SenderUUID != "alice" || got.Message != "hi bob" {
+		t.Errorf("got %+v, want {SenderUUID: alice, Message: hi bob}", got)
+	}
+}